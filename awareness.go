@@ -0,0 +1,73 @@
+package memberlist
+
+import (
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// awareness manages a simple metric for tracking the estimated health of the
+// local node. Modeled after the Lifeguard paper [1], the core idea is that
+// self-health (as judged by whether our own probes and refutes are
+// succeeding) can be used to scale our own timeouts, so a flapping local
+// node doesn't cascade false-positive failures into the rest of the
+// cluster.
+//
+// [1] https://arxiv.org/abs/1707.00788
+type awareness struct {
+	sync.RWMutex
+
+	// max is the upper threshold for the timeout scale (the score will be
+	// constrained to be from 0 <= score < max).
+	max int
+
+	// score is the current awareness score. Lower values are healthier and
+	// zero is the minimum value.
+	score int
+}
+
+// newAwareness returns a new awareness object.
+func newAwareness(max int) *awareness {
+	return &awareness{
+		max:   max,
+		score: 0,
+	}
+}
+
+// ApplyDelta takes the given delta and applies it to the score in a
+// thread-safe manner. It's expected that the caller will use a positive
+// delta to indicate a failed probe/refute and a negative delta to indicate
+// a successful one.
+func (a *awareness) ApplyDelta(delta int) {
+	a.Lock()
+	initial := a.score
+	a.score += delta
+	if a.score < 0 {
+		a.score = 0
+	} else if a.score > (a.max - 1) {
+		a.score = a.max - 1
+	}
+	final := a.score
+	a.Unlock()
+
+	if final != initial {
+		metrics.SetGauge([]string{"memberlist", "health", "score"}, float32(final))
+	}
+}
+
+// GetHealthScore returns the current health score.
+func (a *awareness) GetHealthScore() int {
+	a.RLock()
+	score := a.score
+	a.RUnlock()
+	return score
+}
+
+// ScaleTimeout takes the given duration and scales it by the current health
+// score, plus one (so a health score of 0 gives the base timeout back
+// unchanged).
+func (a *awareness) ScaleTimeout(timeout time.Duration) time.Duration {
+	score := a.GetHealthScore()
+	return timeout * time.Duration(score+1)
+}
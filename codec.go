@@ -0,0 +1,184 @@
+package memberlist
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Codec abstracts the wire encoding used for gossiped messages, so
+// projects embedding memberlist can swap in protobuf or a tuned msgpack
+// handle without forking encode/decode. The on-wire messageType byte
+// prefix is unaffected by the choice of Codec; only the bytes that follow
+// it are produced and consumed by the configured Codec.
+type Codec interface {
+	// Marshal encodes v, returning the bytes that should follow the
+	// messageType prefix on the wire.
+	Marshal(msgType messageType, v interface{}) ([]byte, error)
+
+	// Unmarshal decodes buf (with the messageType prefix already
+	// stripped) into v.
+	Unmarshal(buf []byte, v interface{}) error
+
+	// Name identifies the codec for the handshake id exchange below. It
+	// should be stable across versions of the same codec.
+	Name() string
+}
+
+const msgpackCodecName = "msgpack"
+
+// msgpackCodecID is reserved: it is always the first byte written by
+// writeCodecHandshake for the default Codec, and is what a remote that
+// never advertised a codec (an older memberlist version) is assumed to
+// mean.
+const msgpackCodecID byte = 0
+
+var (
+	// codecRegistryMu guards codecIDs and codecsByID, since RegisterCodec
+	// can be called concurrently with an in-flight handshake.
+	codecRegistryMu sync.RWMutex
+
+	// codecIDs assigns each registered Codec a single byte id so two ends
+	// of a push/pull connection can agree on one without sending the name
+	// itself.
+	codecIDs = map[string]byte{
+		msgpackCodecName: msgpackCodecID,
+	}
+
+	// codecsByID is the reverse of codecIDs, used to resolve the single
+	// byte read off the wire during the handshake back to a Codec
+	// implementation.
+	codecsByID = map[byte]Codec{
+		msgpackCodecID: &msgpackCodec{},
+	}
+)
+
+// RegisterCodec makes c available for handshake negotiation under id, so a
+// project embedding memberlist can plug in e.g. a protobuf Codec instead of
+// forking the handshake. id 0 is reserved for msgpack. Call this from an
+// init() before constructing a Config that sets Config.Codec to c.
+func RegisterCodec(id byte, c Codec) error {
+	if id == msgpackCodecID {
+		return fmt.Errorf("codec id %d is reserved for msgpack", msgpackCodecID)
+	}
+
+	codecRegistryMu.Lock()
+	defer codecRegistryMu.Unlock()
+
+	if existing, ok := codecsByID[id]; ok {
+		return fmt.Errorf("codec id %d is already registered to %q", id, existing.Name())
+	}
+	codecsByID[id] = c
+	codecIDs[c.Name()] = id
+	return nil
+}
+
+// msgpackCodec is the default Codec, and preserves the exact on-wire
+// format memberlist has always used.
+type msgpackCodec struct{}
+
+func (*msgpackCodec) Marshal(msgType messageType, v interface{}) ([]byte, error) {
+	buf, err := encode(msgType, v)
+	if err != nil {
+		return nil, err
+	}
+	// encode() also writes the messageType prefix; strip it back off
+	// since Codec.Marshal's contract is to return only the payload that
+	// follows the prefix.
+	return buf.Bytes()[1:], nil
+}
+
+func (*msgpackCodec) Unmarshal(buf []byte, v interface{}) error {
+	return decode(buf, v)
+}
+
+func (*msgpackCodec) Name() string {
+	return msgpackCodecName
+}
+
+// negotiateCodec picks the Codec to use for a push/pull connection given
+// the single byte the remote end advertised during the handshake. If the
+// remote didn't advertise a recognized codec id, or advertised one we
+// don't have registered, we fall back to msgpack so older or differently
+// configured peers keep working unmodified.
+func negotiateCodec(remoteID byte, local Codec) Codec {
+	codecRegistryMu.RLock()
+	remote, ok := codecsByID[remoteID]
+	codecRegistryMu.RUnlock()
+
+	if ok && remote.Name() == local.Name() {
+		return local
+	}
+	return &msgpackCodec{}
+}
+
+// defaultCodec returns the Codec used when Config.Codec is left unset.
+func defaultCodec() Codec {
+	return &msgpackCodec{}
+}
+
+// writeCodecHandshake writes the single-byte codec id for c as the first
+// byte of a push/pull connection, before any payload. It's the sending
+// half of the handshake described on Codec: both ends exchange this byte
+// so they can agree on a Codec, with an unregistered or absent byte
+// defaulting the reader to msgpack.
+func writeCodecHandshake(w io.Writer, c Codec) error {
+	codecRegistryMu.RLock()
+	id, ok := codecIDs[c.Name()]
+	codecRegistryMu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("codec %q is not registered", c.Name())
+	}
+	_, err := w.Write([]byte{id})
+	return err
+}
+
+// readCodecHandshake reads the single byte the remote end of a push/pull
+// connection wrote with writeCodecHandshake, and returns the Codec both
+// ends should use: local if the remote advertised the same Codec, or
+// msgpack as a safe fallback otherwise.
+func readCodecHandshake(r io.Reader, local Codec) (Codec, error) {
+	var idBuf [1]byte
+	if _, err := io.ReadFull(r, idBuf[:]); err != nil {
+		return nil, fmt.Errorf("failed to read codec handshake byte: %w", err)
+	}
+	return negotiateCodec(idBuf[0], local), nil
+}
+
+// negotiateCodecHandshake performs the codec handshake on rw from one end
+// of a push/pull connection: it writes local's id, then reads the byte the
+// peer wrote, and returns the Codec negotiateCodec picks. Both ends must
+// call this (not just one writing while the other reads) for negotiation
+// to actually agree: negotiateCodec is deterministic given each side's own
+// local Codec and the peer's id, so calling this symmetrically on both
+// ends converges on the same answer on both ends without either having to
+// transmit what it decided. A one-sided exchange lets only the reading
+// end discover a mismatch, leaving the writing end to keep using its own
+// Codec against a peer that silently fell back to msgpack.
+func negotiateCodecHandshake(rw io.ReadWriter, local Codec) (Codec, error) {
+	if err := writeCodecHandshake(rw, local); err != nil {
+		return nil, err
+	}
+	return readCodecHandshake(rw, local)
+}
+
+// encodeWithCodec is the Codec-aware counterpart to encode(): it writes the
+// messageType prefix followed by c.Marshal(msgType, in).
+func encodeWithCodec(c Codec, msgType messageType, in interface{}) (*bytes.Buffer, error) {
+	payload, err := c.Marshal(msgType, in)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte(uint8(msgType))
+	buf.Write(payload)
+	return buf, nil
+}
+
+// decodeWithCodec is the Codec-aware counterpart to decode(): buf must
+// already have the messageType prefix stripped.
+func decodeWithCodec(c Codec, buf []byte, out interface{}) error {
+	return c.Unmarshal(buf, out)
+}
@@ -0,0 +1,193 @@
+package memberlist
+
+import (
+	"net"
+	"testing"
+)
+
+// stubCodec is a second Codec used to exercise RegisterCodec and
+// negotiation. Its Marshal and Unmarshal do nothing; the tests below only
+// compare Name().
+type stubCodec struct{ name string }
+
+func (s *stubCodec) Marshal(msgType messageType, v interface{}) ([]byte, error) { return nil, nil }
+func (s *stubCodec) Unmarshal(buf []byte, v interface{}) error                  { return nil }
+func (s *stubCodec) Name() string                                               { return s.name }
+
+func TestRegisterCodec_RejectsReservedID(t *testing.T) {
+	if err := RegisterCodec(msgpackCodecID, &stubCodec{name: "nope"}); err == nil {
+		t.Fatalf("expected registering codec id 0 to fail")
+	}
+}
+
+func TestRegisterCodec_RejectsDuplicateID(t *testing.T) {
+	const id = byte(200)
+	if err := RegisterCodec(id, &stubCodec{name: "first"}); err != nil {
+		t.Fatalf("unexpected error registering first codec: %v", err)
+	}
+	if err := RegisterCodec(id, &stubCodec{name: "second"}); err == nil {
+		t.Fatalf("expected registering a duplicate codec id to fail")
+	}
+}
+
+func TestCodecHandshake_RoundTripsOverConn(t *testing.T) {
+	const id = byte(201)
+	proto := &stubCodec{name: "proto"}
+	if err := RegisterCodec(id, proto); err != nil {
+		t.Fatalf("failed to register codec: %v", err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- writeCodecHandshake(client, proto)
+	}()
+
+	got, err := readCodecHandshake(server, proto)
+	if err != nil {
+		t.Fatalf("readCodecHandshake failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("writeCodecHandshake failed: %v", err)
+	}
+	if got.Name() != proto.Name() {
+		t.Fatalf("expected negotiated codec %q, got %q", proto.Name(), got.Name())
+	}
+}
+
+func TestCodecHandshake_FallsBackToMsgpackForUnknownID(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		// An id nobody has registered.
+		client.Write([]byte{250})
+	}()
+
+	got, err := readCodecHandshake(server, defaultCodec())
+	if err != nil {
+		t.Fatalf("readCodecHandshake failed: %v", err)
+	}
+	if got.Name() != msgpackCodecName {
+		t.Fatalf("expected fallback to %q, got %q", msgpackCodecName, got.Name())
+	}
+}
+
+// newLoopbackConnPair returns the two ends of a loopback TCP connection.
+// Unlike net.Pipe, a TCP connection buffers writes, which
+// negotiateCodecHandshake relies on: each end writes its id before it
+// reads the peer's.
+func newLoopbackConnPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on loopback: %v", err)
+	}
+	defer ln.Close()
+
+	acceptCh := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		acceptCh <- conn
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial loopback listener: %v", err)
+	}
+	server := <-acceptCh
+	if server == nil {
+		client.Close()
+		t.Fatalf("failed to accept loopback connection")
+	}
+	return client, server
+}
+
+// runNegotiateCodecHandshake negotiates on both ends of a connection
+// concurrently, the way DialTimeout and acceptStreams each call
+// negotiateCodecHandshake on their own side of a stream, and returns what
+// each side landed on.
+func runNegotiateCodecHandshake(t *testing.T, client, server net.Conn, clientCodec, serverCodec Codec) (Codec, Codec) {
+	t.Helper()
+
+	type result struct {
+		codec Codec
+		err   error
+	}
+	clientCh := make(chan result, 1)
+	serverCh := make(chan result, 1)
+
+	go func() {
+		c, err := negotiateCodecHandshake(client, clientCodec)
+		clientCh <- result{c, err}
+	}()
+	go func() {
+		c, err := negotiateCodecHandshake(server, serverCodec)
+		serverCh <- result{c, err}
+	}()
+
+	clientResult := <-clientCh
+	serverResult := <-serverCh
+	if clientResult.err != nil {
+		t.Fatalf("client-side negotiation failed: %v", clientResult.err)
+	}
+	if serverResult.err != nil {
+		t.Fatalf("server-side negotiation failed: %v", serverResult.err)
+	}
+	return clientResult.codec, serverResult.codec
+}
+
+func TestNegotiateCodecHandshake_BothEndsAgreeOnSharedCodec(t *testing.T) {
+	const id = byte(202)
+	proto := &stubCodec{name: "shared-proto"}
+	if err := RegisterCodec(id, proto); err != nil {
+		t.Fatalf("failed to register codec: %v", err)
+	}
+
+	client, server := newLoopbackConnPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	clientCodec, serverCodec := runNegotiateCodecHandshake(t, client, server, proto, proto)
+	if clientCodec.Name() != proto.Name() {
+		t.Fatalf("expected client to negotiate %q, got %q", proto.Name(), clientCodec.Name())
+	}
+	if serverCodec.Name() != proto.Name() {
+		t.Fatalf("expected server to negotiate %q, got %q", proto.Name(), serverCodec.Name())
+	}
+}
+
+// TestNegotiateCodecHandshake_MismatchFallsBackOnBothEnds guards against a
+// one-directional handshake: if only the reading side ran negotiateCodec,
+// a dialer configured for a Codec the acceptor doesn't share would keep
+// encoding with its own Codec while the acceptor silently decoded with
+// msgpack, corrupting every message. Both ends must fall back together.
+func TestNegotiateCodecHandshake_MismatchFallsBackOnBothEnds(t *testing.T) {
+	const clientID = byte(203)
+	const serverID = byte(204)
+	clientCodec := &stubCodec{name: "client-proto"}
+	serverCodec := &stubCodec{name: "server-proto"}
+	if err := RegisterCodec(clientID, clientCodec); err != nil {
+		t.Fatalf("failed to register client codec: %v", err)
+	}
+	if err := RegisterCodec(serverID, serverCodec); err != nil {
+		t.Fatalf("failed to register server codec: %v", err)
+	}
+
+	client, server := newLoopbackConnPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	gotClient, gotServer := runNegotiateCodecHandshake(t, client, server, clientCodec, serverCodec)
+	if gotClient.Name() != msgpackCodecName {
+		t.Fatalf("expected client to fall back to %q, got %q", msgpackCodecName, gotClient.Name())
+	}
+	if gotServer.Name() != msgpackCodecName {
+		t.Fatalf("expected server to fall back to %q, got %q", msgpackCodecName, gotServer.Name())
+	}
+}
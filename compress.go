@@ -0,0 +1,103 @@
+package memberlist
+
+import (
+	"bytes"
+	"compress/lzw"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Constant litWidth 2-8
+	lzwLitWidth = 8
+)
+
+// snappyAlgo and zstdAlgo extend the original lzwAlgo enum. They are defined
+// relative to lzwAlgo, rather than their own iota block, so that the wire
+// value of lzwAlgo is unaffected regardless of where these constants live.
+const (
+	snappyAlgo compressionType = lzwAlgo + 1
+	zstdAlgo   compressionType = lzwAlgo + 2
+)
+
+// compressionCodec implements a single compression algorithm that can be
+// registered in compressAlgos and selected via compress{}.Algo.
+type compressionCodec interface {
+	compress(dst *bytes.Buffer, inp []byte) error
+	decompress(inp []byte) ([]byte, error)
+}
+
+// compressAlgos is the registry of all algorithms we know how to produce and
+// consume, keyed on the algo byte carried in the compress{} message. Decode
+// always looks up through this full table so that a mixed-version cluster
+// keeps working while a rolling upgrade changes Config.CompressionAlgo out
+// from under us.
+var compressAlgos = map[compressionType]compressionCodec{
+	lzwAlgo:    &lzwCodec{},
+	snappyAlgo: &snappyCodec{},
+	zstdAlgo:   &zstdCodec{},
+}
+
+// lzwCodec is the original compression used by memberlist. It has a poor
+// ratio/CPU tradeoff on the small msgpack frames memberlist gossips, but is
+// kept as the default for backward compatibility.
+type lzwCodec struct{}
+
+func (*lzwCodec) compress(dst *bytes.Buffer, inp []byte) error {
+	compressor := lzw.NewWriter(dst, lzw.LSB, lzwLitWidth)
+	if _, err := compressor.Write(inp); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+func (*lzwCodec) decompress(inp []byte) ([]byte, error) {
+	uncomp := lzw.NewReader(bytes.NewReader(inp), lzw.LSB, lzwLitWidth)
+	defer uncomp.Close()
+
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, uncomp); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// snappyCodec trades a little compression ratio for much lower CPU cost,
+// which suits the small, frequent packets exchanged during probing.
+type snappyCodec struct{}
+
+func (*snappyCodec) compress(dst *bytes.Buffer, inp []byte) error {
+	dst.Write(snappy.Encode(nil, inp))
+	return nil
+}
+
+func (*snappyCodec) decompress(inp []byte) ([]byte, error) {
+	return snappy.Decode(nil, inp)
+}
+
+// zstdCodec gives the best ratio of the three, which matters most for the
+// large states exchanged during push/pull syncs.
+type zstdCodec struct{}
+
+func (*zstdCodec) compress(dst *bytes.Buffer, inp []byte) error {
+	enc, err := zstd.NewWriter(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := enc.Write(inp); err != nil {
+		enc.Close()
+		return err
+	}
+	return enc.Close()
+}
+
+func (*zstdCodec) decompress(inp []byte) ([]byte, error) {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	return dec.DecodeAll(inp, nil)
+}
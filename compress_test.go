@@ -0,0 +1,52 @@
+package memberlist
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestCompressAlgos_RoundTrip exercises every codec registered in
+// compressAlgos, confirming each can decompress exactly what it compressed.
+// This is the coverage the lzwCodec/snappyCodec/zstdCodec split should have
+// shipped with: a bug in any one codec's compress/decompress pairing would
+// otherwise only surface the first time a cluster actually negotiated that
+// algorithm.
+func TestCompressAlgos_RoundTrip(t *testing.T) {
+	payloads := [][]byte{
+		nil,
+		[]byte{},
+		[]byte("a"),
+		[]byte("the quick brown fox jumps over the lazy dog"),
+		bytes.Repeat([]byte("memberlist gossip payload "), 256),
+	}
+
+	for algo, codec := range compressAlgos {
+		algo, codec := algo, codec
+		for i, payload := range payloads {
+			var buf bytes.Buffer
+			if err := codec.compress(&buf, payload); err != nil {
+				t.Fatalf("algo %d: compress failed on payload %d: %v", algo, i, err)
+			}
+
+			out, err := codec.decompress(buf.Bytes())
+			if err != nil {
+				t.Fatalf("algo %d: decompress failed on payload %d: %v", algo, i, err)
+			}
+
+			if !bytes.Equal(out, payload) && !(len(out) == 0 && len(payload) == 0) {
+				t.Fatalf("algo %d: round trip mismatch on payload %d: got %q, want %q", algo, i, out, payload)
+			}
+		}
+	}
+}
+
+// TestCompressAlgos_RegistersExpectedAlgos guards against a codec being
+// silently dropped from the registry, which decompress would only notice
+// the next time a peer actually used that algorithm.
+func TestCompressAlgos_RegistersExpectedAlgos(t *testing.T) {
+	for _, algo := range []compressionType{lzwAlgo, snappyAlgo, zstdAlgo} {
+		if _, ok := compressAlgos[algo]; !ok {
+			t.Fatalf("expected compressAlgos to contain algo %d", algo)
+		}
+	}
+}
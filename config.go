@@ -0,0 +1,65 @@
+package memberlist
+
+import "bytes"
+
+// Config holds the send-path settings that the compression and codec
+// registries in compress.go/codec.go need to pick a default. It doesn't
+// attempt to reproduce the rest of memberlist's configuration (bind
+// address, timing knobs, delegate hooks, ...), which lives outside this
+// slice of the package; it only adds what CompressionAlgo and Codec need
+// to be selectable at runtime instead of hard-coded.
+type Config struct {
+	// CompressionAlgo selects which compressionCodec in compressAlgos is
+	// used to compress outbound payloads. Defaults to lzwAlgo for
+	// backward compatibility with memberlist's historical wire format.
+	// decompressBuffer always accepts every algorithm in compressAlgos
+	// regardless of this setting, so a rolling upgrade that changes
+	// CompressionAlgo on some nodes before others doesn't break the
+	// cluster.
+	CompressionAlgo compressionType
+
+	// Codec selects the wire encoding used by Config.encode and
+	// Config.decode. Left nil, it defaults to msgpack via defaultCodec().
+	// Config.encode and Config.decode always use this Codec: they do not
+	// consult the Codec negotiated by the push/pull handshake in codec.go,
+	// which the transports run with their own NetTransportConfig.Codec or
+	// QUICTransportConfig.Codec.
+	Codec Codec
+}
+
+// DefaultConfig returns a Config with lzwAlgo compression and msgpack,
+// memberlist's historical defaults, so an existing deployment's wire
+// format doesn't change until it opts into something else.
+func DefaultConfig() *Config {
+	return &Config{
+		CompressionAlgo: lzwAlgo,
+		Codec:           defaultCodec(),
+	}
+}
+
+// compress compresses inp with the algorithm configured on c, wrapping it
+// in an encoded compress{} message ready to send.
+func (c *Config) compress(inp []byte) (*bytes.Buffer, error) {
+	return compressPayload(c.CompressionAlgo, inp)
+}
+
+// encode encodes in as msgType using the Codec configured on c, falling
+// back to msgpack if none was set.
+func (c *Config) encode(msgType messageType, in interface{}) (*bytes.Buffer, error) {
+	codec := c.Codec
+	if codec == nil {
+		codec = defaultCodec()
+	}
+	return encodeWithCodec(codec, msgType, in)
+}
+
+// decode decodes buf (with the messageType prefix already stripped) into
+// out using the Codec configured on c, falling back to msgpack if none was
+// set.
+func (c *Config) decode(buf []byte, out interface{}) error {
+	codec := c.Codec
+	if codec == nil {
+		codec = defaultCodec()
+	}
+	return decodeWithCodec(codec, buf, out)
+}
@@ -0,0 +1,280 @@
+package memberlist
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// NetTransportConfig is used to configure a NetTransport.
+type NetTransportConfig struct {
+	// BindAddrs is a list of addresses to bind to for both TCP and UDP
+	// communication.
+	BindAddrs []string
+
+	// BindPort is the port to listen on, for each address above.
+	BindPort int
+
+	// Logger is used to surface accept/read errors. May be left nil.
+	Logger interface {
+		Printf(format string, args ...interface{})
+	}
+
+	// Codec is exchanged as the first byte of every push/pull TCP stream,
+	// mirroring QUICTransportConfig.Codec, so both ends of a push/pull
+	// connection agree on an encoding before anything else is sent.
+	// Defaults to msgpack if left nil.
+	Codec Codec
+}
+
+// NetTransport is a Transport implementation using plain TCP for the
+// stream abstraction and plain UDP for the packet abstraction, the
+// original memberlist transport that QUICTransport and QueueingTransport
+// can be run alongside during a rollout.
+type NetTransport struct {
+	config       NetTransportConfig
+	packetCh     chan *Packet
+	streamCh     chan net.Conn
+	tcpListeners []*net.TCPListener
+	udpListeners []*net.UDPConn
+
+	// codec is exchanged over every TCP stream's handshake; see
+	// NetTransportConfig.Codec.
+	codec Codec
+
+	shutdownLock sync.Mutex
+	shutdown     bool
+}
+
+// NewNetTransport creates and starts listening on all of the addresses in
+// config.BindAddrs for both TCP and UDP traffic.
+func NewNetTransport(config *NetTransportConfig) (*NetTransport, error) {
+	if len(config.BindAddrs) == 0 {
+		return nil, fmt.Errorf("at least one bind address is required")
+	}
+
+	codec := config.Codec
+	if codec == nil {
+		codec = defaultCodec()
+	}
+
+	t := &NetTransport{
+		config:   *config,
+		codec:    codec,
+		packetCh: make(chan *Packet),
+		streamCh: make(chan net.Conn),
+	}
+
+	for _, addr := range config.BindAddrs {
+		ip := net.ParseIP(addr)
+
+		tcpAddr := &net.TCPAddr{IP: ip, Port: config.BindPort}
+		tcpLn, err := net.ListenTCP("tcp", tcpAddr)
+		if err != nil {
+			t.Shutdown()
+			return nil, fmt.Errorf("failed to start TCP listener on %q: %w", tcpAddr.String(), err)
+		}
+		t.tcpListeners = append(t.tcpListeners, tcpLn)
+		go t.tcpAcceptLoop(tcpLn)
+
+		udpAddr := &net.UDPAddr{IP: ip, Port: config.BindPort}
+		udpLn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			t.Shutdown()
+			return nil, fmt.Errorf("failed to start UDP listener on %q: %w", udpAddr.String(), err)
+		}
+		t.udpListeners = append(t.udpListeners, udpLn)
+		go t.udpReadLoop(udpLn)
+	}
+
+	return t, nil
+}
+
+// tcpAcceptLoop accepts inbound stream connections and hands them to
+// streamCh. Transient Accept errors (fd exhaustion, a TLS handshake storm
+// in front of the listener, a momentarily overloaded kernel accept queue)
+// are backed off with handleAcceptErr instead of hot-looping and spamming
+// logs; a successful accept resets the backoff.
+func (t *NetTransport) tcpAcceptLoop(ln *net.TCPListener) {
+	var loopDelay time.Duration
+	for {
+		conn, err := ln.AcceptTCP()
+		if err != nil {
+			if t.isShutdown() {
+				return
+			}
+			delay := handleAcceptErr(err, &loopDelay)
+			if t.config.Logger != nil {
+				t.config.Logger.Printf("[ERR] memberlist: Error accepting TCP connection: %v (will retry after %s)", err, delay)
+			}
+			time.Sleep(delay)
+			continue
+		}
+		handleAcceptErrReset(&loopDelay)
+
+		go t.acceptStream(conn)
+	}
+}
+
+// acceptStream runs the codec handshake on an accepted TCP connection
+// before handing it to streamCh, mirroring QUICTransport.acceptStreams: it
+// writes our id and reads the dialer's so both ends converge on the same
+// negotiated Codec. It runs in its own goroutine per connection, rather
+// than inline in tcpAcceptLoop, so a dialer that's slow to write its half
+// of the handshake can't stall tcpAcceptLoop from accepting the next
+// connection.
+func (t *NetTransport) acceptStream(conn *net.TCPConn) {
+	negotiated, err := negotiateCodecHandshake(conn, t.codec)
+	if err != nil {
+		if t.config.Logger != nil {
+			t.config.Logger.Printf("[ERR] memberlist: Error negotiating codec handshake from %s: %v", conn.RemoteAddr(), err)
+		}
+		conn.Close()
+		return
+	}
+
+	t.streamCh <- &netStreamConn{Conn: conn, codec: negotiated}
+}
+
+// udpReadLoop reads inbound packets and hands them to packetCh. It uses
+// the same handleAcceptErr/handleAcceptErrReset backoff as the TCP accept
+// loop above, since a UDP ReadFrom can fail for the same transient reasons
+// (fd exhaustion in particular) and would otherwise hot-loop identically.
+func (t *NetTransport) udpReadLoop(conn *net.UDPConn) {
+	var loopDelay time.Duration
+	buf := make([]byte, udpPacketBufSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if t.isShutdown() {
+				return
+			}
+			delay := handleAcceptErr(err, &loopDelay)
+			if t.config.Logger != nil {
+				t.config.Logger.Printf("[ERR] memberlist: Error reading UDP packet: %v (will retry after %s)", err, delay)
+			}
+			time.Sleep(delay)
+			continue
+		}
+		handleAcceptErrReset(&loopDelay)
+
+		if n < 1 {
+			continue
+		}
+
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+		t.packetCh <- &Packet{
+			Buf:       msg,
+			From:      addr,
+			Timestamp: time.Now(),
+		}
+	}
+}
+
+// udpPacketBufSize is sized the same as memberlist's historical default
+// UDP buffer size.
+const udpPacketBufSize = 65536
+
+// FinalAdvertiseAddr is given the user's configured values (which might be
+// empty) and returns the desired IP and port to advertise to the rest of
+// the cluster.
+func (t *NetTransport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error) {
+	if ip != "" {
+		advertiseAddr := net.ParseIP(ip)
+		if advertiseAddr == nil {
+			return nil, 0, fmt.Errorf("failed to parse advertise address %q", ip)
+		}
+		return advertiseAddr, port, nil
+	}
+	if len(t.tcpListeners) == 0 {
+		return nil, 0, fmt.Errorf("no listeners bound")
+	}
+	addr := t.tcpListeners[0].Addr().(*net.TCPAddr)
+	return addr.IP, addr.Port, nil
+}
+
+// WriteTo sends b to addr over UDP, returning a timestamp taken as close
+// as possible to the write for RTT accounting.
+func (t *NetTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(t.udpListeners) == 0 {
+		return time.Time{}, fmt.Errorf("no UDP listeners bound")
+	}
+
+	sendTime := time.Now()
+	_, err = t.udpListeners[0].WriteTo(b, udpAddr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return sendTime, nil
+}
+
+// PacketCh returns a channel that can be read to receive incoming packets
+// from other peers.
+func (t *NetTransport) PacketCh() <-chan *Packet {
+	return t.packetCh
+}
+
+// DialTimeout opens a TCP connection to addr for push/pull, and runs the
+// codec handshake with the acceptor (writing our id, then reading
+// theirs), returning the connection wrapped with the negotiated Codec.
+func (t *NetTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	negotiated, err := negotiateCodecHandshake(conn, t.codec)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to negotiate codec handshake: %w", err)
+	}
+
+	return &netStreamConn{Conn: conn, codec: negotiated}, nil
+}
+
+// netStreamConn adapts a TCP net.Conn to carry the Codec negotiated for it
+// during the handshake in DialTimeout/acceptStream, mirroring
+// quicStreamConn so push/pull code can query Codec() the same way
+// regardless of which Transport produced the stream.
+type netStreamConn struct {
+	net.Conn
+	codec Codec
+}
+
+// Codec returns the Codec negotiated for this connection during the
+// handshake, so push/pull code can decode the payload that follows with
+// the right encoding.
+func (c *netStreamConn) Codec() Codec { return c.codec }
+
+// StreamCh returns a channel that can be read to handle incoming stream
+// connections from other peers.
+func (t *NetTransport) StreamCh() <-chan net.Conn {
+	return t.streamCh
+}
+
+// Shutdown closes every TCP and UDP listener.
+func (t *NetTransport) Shutdown() error {
+	t.shutdownLock.Lock()
+	t.shutdown = true
+	t.shutdownLock.Unlock()
+
+	for _, ln := range t.tcpListeners {
+		ln.Close()
+	}
+	for _, ln := range t.udpListeners {
+		ln.Close()
+	}
+	return nil
+}
+
+func (t *NetTransport) isShutdown() bool {
+	t.shutdownLock.Lock()
+	defer t.shutdownLock.Unlock()
+	return t.shutdown
+}
@@ -0,0 +1,48 @@
+package memberlist
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNetTransport_PacketAndStreamRoundTrip(t *testing.T) {
+	a, err := NewNetTransport(&NetTransportConfig{BindAddrs: []string{"127.0.0.1"}, BindPort: 0})
+	if err != nil {
+		t.Fatalf("failed to start transport a: %v", err)
+	}
+	defer a.Shutdown()
+
+	b, err := NewNetTransport(&NetTransportConfig{BindAddrs: []string{"127.0.0.1"}, BindPort: 0})
+	if err != nil {
+		t.Fatalf("failed to start transport b: %v", err)
+	}
+	defer b.Shutdown()
+
+	bAddr := b.udpListeners[0].LocalAddr().String()
+	if _, err := a.WriteTo([]byte("hello"), bAddr); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	select {
+	case pkt := <-b.PacketCh():
+		if string(pkt.Buf) != "hello" {
+			t.Fatalf("expected %q, got %q", "hello", pkt.Buf)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for packet")
+	}
+
+	bTCPAddr := b.tcpListeners[0].Addr().String()
+	conn, err := a.DialTimeout(bTCPAddr, time.Second)
+	if err != nil {
+		t.Fatalf("DialTimeout failed: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case stream := <-b.StreamCh():
+		stream.Close()
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for inbound stream")
+	}
+}
@@ -0,0 +1,442 @@
+package memberlist
+
+import (
+	"sync"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// sendPriority classifies outbound messages so urgent failure-detection
+// traffic never queues behind bulk user broadcasts bound for the same
+// peer.
+type sendPriority int
+
+const (
+	// priorityFailureDetection carries alive/suspect/dead/refute messages.
+	priorityFailureDetection sendPriority = iota
+
+	// priorityMembership carries join/leave/push-pull hints.
+	priorityMembership
+
+	// priorityUser carries user broadcasts queued via the Delegate.
+	priorityUser
+
+	numPriorities = int(priorityUser) + 1
+)
+
+// peerQueueHighWaterMark is the number of queued messages above which a
+// peer's queue starts shedding load: the oldest message in the
+// lowest-priority non-empty class is dropped first (see
+// peerSendQueue.dropOldestLocked), since user traffic is the least urgent
+// and the most likely to be stale by the time it'd be sent anyway — but
+// failure-detection and membership traffic is shed too rather than letting
+// the queue grow without bound if a peer stays unreachable long enough to
+// back those classes up as well.
+const peerQueueHighWaterMark = 1024
+
+// sourceQueue is one source's FIFO sub-queue within a class, plus the
+// share weight Dequeue should give it relative to the class's other
+// sources.
+type sourceQueue struct {
+	weight int
+	credit int
+	msgs   [][]byte
+}
+
+// classQueue holds every source's messages within a single priority class
+// and implements weighted fair share across them: Dequeue round-robins
+// across sources in proportion to weight instead of draining whichever
+// source enqueues fastest, so a single chatty source (e.g. one flapping
+// node's repeated alive/suspect traffic) can't starve another source's
+// messages within the same class.
+type classQueue struct {
+	sources map[string]*sourceQueue
+
+	// order is the round-robin rotation: sources are appended the first
+	// time they're seen and dropped once their sub-queue drains, so the
+	// rotation only ever walks sources that currently have a weight
+	// assigned.
+	order  []string
+	cursor int
+}
+
+func newClassQueue() *classQueue {
+	return &classQueue{sources: make(map[string]*sourceQueue)}
+}
+
+func (c *classQueue) enqueue(source string, weight int, msg []byte) {
+	if weight < 1 {
+		weight = 1
+	}
+
+	sq, ok := c.sources[source]
+	if !ok {
+		sq = &sourceQueue{weight: weight, credit: weight}
+		c.sources[source] = sq
+		c.order = append(c.order, source)
+	} else {
+		sq.weight = weight
+	}
+	sq.msgs = append(sq.msgs, msg)
+}
+
+func (c *classQueue) len() int {
+	n := 0
+	for _, sq := range c.sources {
+		n += len(sq.msgs)
+	}
+	return n
+}
+
+// dequeue pops the next message using weighted round robin: each source is
+// served up to its weight worth of messages per lap before the rotation
+// moves to the next source, so a higher-weight source gets proportionally
+// more of the class's share without the lower-weight sources being starved
+// outright. A source with an empty sub-queue is dropped from the rotation
+// as it's encountered.
+func (c *classQueue) dequeue() ([]byte, bool) {
+	for {
+		if len(c.order) == 0 {
+			return nil, false
+		}
+		if c.cursor >= len(c.order) {
+			c.cursor = 0
+		}
+
+		source := c.order[c.cursor]
+		sq := c.sources[source]
+		if sq == nil || len(sq.msgs) == 0 {
+			c.order = append(c.order[:c.cursor], c.order[c.cursor+1:]...)
+			delete(c.sources, source)
+			continue
+		}
+
+		if sq.credit <= 0 {
+			sq.credit = sq.weight
+			c.cursor = (c.cursor + 1) % len(c.order)
+			continue
+		}
+
+		msg := sq.msgs[0]
+		sq.msgs = sq.msgs[1:]
+		sq.credit--
+		if sq.credit == 0 {
+			c.cursor = (c.cursor + 1) % len(c.order)
+		}
+		return msg, true
+	}
+}
+
+// dropOldest discards one message from the source at the front of the
+// rotation that still has one, approximating "the oldest message in the
+// class" without tracking a cross-source arrival timestamp for every
+// message.
+func (c *classQueue) dropOldest() bool {
+	for _, source := range c.order {
+		sq := c.sources[source]
+		if sq != nil && len(sq.msgs) > 0 {
+			sq.msgs = sq.msgs[1:]
+			return true
+		}
+	}
+	return false
+}
+
+// peerSendQueue is a single peer's bounded, priority-classed outbound
+// queue. It replaces sharing one broadcast.TransmitLimitedQueue across all
+// peers: each peer now drains independently, so one slow peer's retransmit
+// backlog can't starve another peer's failure-detection traffic or force
+// everyone's retransmitMult up.
+type peerSendQueue struct {
+	mu sync.Mutex
+
+	addr    string
+	classes [numPriorities]*classQueue
+
+	// dropped counts messages dropped because the queue crossed
+	// peerQueueHighWaterMark.
+	dropped uint64
+
+	// slow marks that this peer tripped backpressure. Gossip fan-out
+	// should skip a slow peer for one cycle, the same way it would skip
+	// a peer with a poor awareness-style health score.
+	slow bool
+
+	// notify wakes this peer's drain worker as soon as Enqueue has
+	// something for it, instead of the worker polling on a timer. It's
+	// buffered by one: a pending, unread wakeup already means "go look at
+	// the queue", so Enqueue's send is non-blocking and collapses any
+	// number of enqueues between wakeups into a single drain pass.
+	notify chan struct{}
+}
+
+func newPeerSendQueue(addr string) *peerSendQueue {
+	q := &peerSendQueue{addr: addr, notify: make(chan struct{}, 1)}
+	for i := range q.classes {
+		q.classes[i] = newClassQueue()
+	}
+	return q
+}
+
+// Enqueue adds msg, attributed to source, to the class it belongs to.
+// Within that class, Dequeue round-robins across sources in proportion to
+// weight (clamped to at least 1) rather than plain FIFO, so one high-rate
+// source can't dominate a class at another source's expense. source and
+// weight are the caller's choice — e.g. the node a failure-detection
+// message is about, or the broadcast that queued a user message — this
+// queue has no way to infer either from the message bytes alone.
+//
+// If the peer's total backlog across every class is already at the
+// high-water mark, the oldest message from the lowest-priority non-empty
+// class is dropped to make room and the peer is marked slow; see
+// dropOldestLocked for why this isn't limited to the user class.
+func (q *peerSendQueue) Enqueue(prio sendPriority, source string, weight int, msg []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.depthLocked() >= peerQueueHighWaterMark {
+		if q.dropOldestLocked() {
+			q.dropped++
+			metrics.IncrCounter([]string{"memberlist", "queue", "dropped"}, 1)
+		}
+		q.slow = true
+		metrics.IncrCounter([]string{"memberlist", "queue", "slowPeer"}, 1)
+	}
+
+	q.classes[prio].enqueue(source, weight, msg)
+	metrics.SetGauge([]string{"memberlist", "queue", "depth", q.addr}, float32(q.depthLocked()))
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// dropOldestLocked sheds one message from the lowest-priority non-empty
+// class (user, then membership, then failure-detection), so load-shedding
+// always takes the least urgent traffic first. It still reaches into
+// failure-detection/membership once those are all that's left, because a
+// peer that stays unreachable long enough backs up every class, and a
+// queue that only ever sheds priorityUser isn't actually bounded: it grows
+// without limit once the user class runs dry.
+func (q *peerSendQueue) dropOldestLocked() bool {
+	for prio := numPriorities - 1; prio >= 0; prio-- {
+		if q.classes[prio].dropOldest() {
+			return true
+		}
+	}
+	return false
+}
+
+// Dequeue pops the next message to send for this peer, draining strictly by
+// priority: failure-detection messages are always served before
+// membership, and membership before user traffic. Callers implementing
+// fairness across peers should call Dequeue round-robin across each peer's
+// queue rather than draining one peer to empty before moving to the next.
+func (q *peerSendQueue) Dequeue() ([]byte, sendPriority, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for prio := 0; prio < numPriorities; prio++ {
+		if msg, ok := q.classes[prio].dequeue(); ok {
+			return msg, sendPriority(prio), true
+		}
+	}
+	return nil, 0, false
+}
+
+// Depth returns the total number of messages queued across all classes.
+func (q *peerSendQueue) Depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.depthLocked()
+}
+
+func (q *peerSendQueue) depthLocked() int {
+	n := 0
+	for _, c := range q.classes {
+		n += c.len()
+	}
+	return n
+}
+
+// Dropped returns the number of messages, from any class, dropped so far
+// due to backpressure.
+func (q *peerSendQueue) Dropped() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// Slow reports whether this peer has tripped backpressure since the last
+// ResetSlow.
+func (q *peerSendQueue) Slow() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.slow
+}
+
+// ResetSlow clears the slow marker, letting the peer back into the normal
+// gossip fan-out rotation. Callers should do this once per gossip cycle
+// after deciding whether to skip the peer.
+func (q *peerSendQueue) ResetSlow() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.slow = false
+}
+
+// peerQueues owns one peerSendQueue per peer address. Gossip fan-out and
+// the failure detector both enqueue through this instead of a single
+// shared TransmitLimitedQueue.
+type peerQueues struct {
+	mu    sync.Mutex
+	peers map[string]*peerSendQueue
+}
+
+func newPeerQueues() *peerQueues {
+	return &peerQueues{peers: make(map[string]*peerSendQueue)}
+}
+
+// For returns the queue for addr, creating one on first use.
+func (p *peerQueues) For(addr string) *peerSendQueue {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	q, ok := p.peers[addr]
+	if !ok {
+		q = newPeerSendQueue(addr)
+		p.peers[addr] = q
+	}
+	return q
+}
+
+// Remove discards the queue for addr, e.g. once that peer has left the
+// cluster.
+func (p *peerQueues) Remove(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.peers, addr)
+}
+
+// Depths returns the current queue depth for every peer we're tracking,
+// keyed by address, for metrics and diagnostics.
+func (p *peerQueues) Depths() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make(map[string]int, len(p.peers))
+	for addr, q := range p.peers {
+		out[addr] = q.Depth()
+	}
+	return out
+}
+
+// QueueingTransport wraps a Transport and gives every destination address
+// its own bounded, priority-classed send queue (a peerSendQueue), so the
+// send path no longer shares one broadcast queue across all peers. Callers
+// that used to hand messages straight to Transport.WriteTo should instead
+// call Enqueue; a per-peer worker goroutine drains that peer's queue in
+// priority order and performs the actual Transport.WriteTo. This is the
+// "managed by the Transport layer" per-peer queue described by the
+// redesign: the wrapped Transport is still what puts bytes on the wire,
+// but everything about ordering, backpressure, and slow-peer tracking now
+// lives here instead of in a single shared queue.
+type QueueingTransport struct {
+	Transport
+
+	queues *peerQueues
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+
+	workersLock sync.Mutex
+	workers     map[string]struct{}
+}
+
+// NewQueueingTransport wraps t so sends can be routed through per-peer
+// priority queues instead of going straight to t.WriteTo.
+func NewQueueingTransport(t Transport) *QueueingTransport {
+	return &QueueingTransport{
+		Transport:  t,
+		queues:     newPeerQueues(),
+		shutdownCh: make(chan struct{}),
+		workers:    make(map[string]struct{}),
+	}
+}
+
+// Enqueue hands msg to addr's queue under the given priority class,
+// attributed to source with the given weight (see peerSendQueue.Enqueue),
+// starting that peer's drain worker the first time we see it.
+func (q *QueueingTransport) Enqueue(addr string, prio sendPriority, source string, weight int, msg []byte) {
+	pq := q.queues.For(addr)
+	pq.Enqueue(prio, source, weight, msg)
+	q.ensureWorker(addr, pq)
+}
+
+// ensureWorker starts the per-peer drain goroutine for addr if one isn't
+// already running.
+func (q *QueueingTransport) ensureWorker(addr string, pq *peerSendQueue) {
+	q.workersLock.Lock()
+	defer q.workersLock.Unlock()
+
+	if _, ok := q.workers[addr]; ok {
+		return
+	}
+	q.workers[addr] = struct{}{}
+
+	q.wg.Add(1)
+	go q.drain(addr, pq)
+}
+
+// drain is the per-peer worker: it sleeps until Enqueue wakes it, then
+// drains the queue in strict priority order down to empty before sleeping
+// again, so a peer's throughput isn't capped by a poll interval and a
+// message doesn't wait on one after it's already queued.
+func (q *QueueingTransport) drain(addr string, pq *peerSendQueue) {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.shutdownCh:
+			return
+		case <-pq.notify:
+		}
+
+		for {
+			msg, _, ok := pq.Dequeue()
+			if !ok {
+				break
+			}
+			// Best effort: a write failure here means the peer is
+			// unreachable, which the failure detector's own probes will
+			// independently notice and act on.
+			q.Transport.WriteTo(msg, addr)
+		}
+	}
+}
+
+// GossipPeers filters candidates down to the ones that aren't currently
+// marked slow, so fan-out skips a backlogged peer for one cycle instead of
+// inflating retransmitMult for everyone. A skipped peer's slow marker is
+// cleared so it's eligible again on the next call.
+func (q *QueueingTransport) GossipPeers(candidates []string) []string {
+	out := make([]string, 0, len(candidates))
+	for _, addr := range candidates {
+		pq := q.queues.For(addr)
+		if pq.Slow() {
+			pq.ResetSlow()
+			metrics.IncrCounter([]string{"memberlist", "queue", "gossipSkip"}, 1)
+			continue
+		}
+		out = append(out, addr)
+	}
+	return out
+}
+
+// Shutdown stops every per-peer worker before delegating to the wrapped
+// Transport's own Shutdown.
+func (q *QueueingTransport) Shutdown() error {
+	close(q.shutdownCh)
+	q.wg.Wait()
+	return q.Transport.Shutdown()
+}
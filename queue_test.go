@@ -0,0 +1,192 @@
+package memberlist
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeTransport is the minimal Transport double used to exercise
+// QueueingTransport without a real socket.
+type fakeTransport struct {
+	writes chan []byte
+}
+
+func newFakeTransport() *fakeTransport {
+	return &fakeTransport{writes: make(chan []byte, 16)}
+}
+
+func (f *fakeTransport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error) {
+	return net.ParseIP("127.0.0.1"), port, nil
+}
+
+func (f *fakeTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	f.writes <- append([]byte(nil), b...)
+	return time.Now(), nil
+}
+
+func (f *fakeTransport) PacketCh() <-chan *Packet { return nil }
+
+func (f *fakeTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return nil, nil
+}
+
+func (f *fakeTransport) StreamCh() <-chan net.Conn { return nil }
+
+func (f *fakeTransport) Shutdown() error { return nil }
+
+func TestPeerSendQueue_PriorityOrder(t *testing.T) {
+	q := newPeerSendQueue("127.0.0.1:7946")
+	q.Enqueue(priorityUser, "src", 1, []byte("user"))
+	q.Enqueue(priorityFailureDetection, "src", 1, []byte("alive"))
+	q.Enqueue(priorityMembership, "src", 1, []byte("join"))
+
+	msg, prio, ok := q.Dequeue()
+	if !ok || string(msg) != "alive" || prio != priorityFailureDetection {
+		t.Fatalf("expected failure-detection message first, got %q prio %d ok %v", msg, prio, ok)
+	}
+
+	msg, prio, ok = q.Dequeue()
+	if !ok || string(msg) != "join" || prio != priorityMembership {
+		t.Fatalf("expected membership message second, got %q prio %d ok %v", msg, prio, ok)
+	}
+
+	msg, prio, ok = q.Dequeue()
+	if !ok || string(msg) != "user" || prio != priorityUser {
+		t.Fatalf("expected user message last, got %q prio %d ok %v", msg, prio, ok)
+	}
+
+	if _, _, ok := q.Dequeue(); ok {
+		t.Fatalf("expected queue to be empty")
+	}
+}
+
+func TestPeerSendQueue_BackpressureDropsOldestUser(t *testing.T) {
+	q := newPeerSendQueue("127.0.0.1:7946")
+
+	for i := 0; i < peerQueueHighWaterMark+5; i++ {
+		q.Enqueue(priorityUser, "src", 1, []byte{byte(i)})
+	}
+
+	if !q.Slow() {
+		t.Fatalf("expected peer to be marked slow after crossing the high-water mark")
+	}
+	if q.Dropped() == 0 {
+		t.Fatalf("expected at least one dropped message")
+	}
+	if msg, _, ok := q.Dequeue(); !ok || msg[0] != 5 {
+		t.Fatalf("expected the 5 oldest user messages to be dropped, got first remaining %v ok %v", msg, ok)
+	}
+
+	q.ResetSlow()
+	if q.Slow() {
+		t.Fatalf("expected ResetSlow to clear the slow marker")
+	}
+}
+
+// TestPeerSendQueue_BackpressureDropsAcrossAllClasses guards against
+// shedding only priorityUser: if a flood of failure-detection/membership
+// traffic arrives with the user class empty, the high-water branch must
+// still drop something and keep the queue bounded instead of appending
+// forever.
+func TestPeerSendQueue_BackpressureDropsAcrossAllClasses(t *testing.T) {
+	q := newPeerSendQueue("127.0.0.1:7946")
+
+	for i := 0; i < peerQueueHighWaterMark+5; i++ {
+		q.Enqueue(priorityFailureDetection, "src", 1, []byte{byte(i)})
+	}
+
+	if !q.Slow() {
+		t.Fatalf("expected peer to be marked slow after crossing the high-water mark")
+	}
+	if q.Dropped() == 0 {
+		t.Fatalf("expected at least one dropped message even with the user class empty")
+	}
+	if got := q.Depth(); got >= peerQueueHighWaterMark+5 {
+		t.Fatalf("expected queue depth to be bounded below the enqueued count, got %d", got)
+	}
+}
+
+// TestPeerSendQueue_WeightedFairShareWithinClass verifies that a
+// higher-weight source gets proportionally more of a class's share than a
+// lower-weight source sharing the same class, instead of one source's
+// messages being served strictly FIFO ahead of another's just because it
+// enqueued first.
+func TestPeerSendQueue_WeightedFairShareWithinClass(t *testing.T) {
+	q := newPeerSendQueue("127.0.0.1:7946")
+
+	for i := 0; i < 4; i++ {
+		q.Enqueue(priorityUser, "heavy", 2, []byte("heavy"))
+	}
+	for i := 0; i < 4; i++ {
+		q.Enqueue(priorityUser, "light", 1, []byte("light"))
+	}
+
+	var heavy, light int
+	for i := 0; i < 6; i++ {
+		msg, _, ok := q.Dequeue()
+		if !ok {
+			t.Fatalf("expected a message on dequeue %d", i)
+		}
+		switch string(msg) {
+		case "heavy":
+			heavy++
+		case "light":
+			light++
+		default:
+			t.Fatalf("unexpected message %q", msg)
+		}
+	}
+
+	// Weight 2 vs weight 1 over two full laps (3 turns each) should land
+	// on a 2:1 split of the first 6 messages served.
+	if heavy != 4 || light != 2 {
+		t.Fatalf("expected a 4:2 split favoring the weight-2 source, got heavy=%d light=%d", heavy, light)
+	}
+}
+
+func TestQueueingTransport_DrainsThroughWrappedTransport(t *testing.T) {
+	ft := newFakeTransport()
+	qt := NewQueueingTransport(ft)
+	defer qt.Shutdown()
+
+	qt.Enqueue("127.0.0.1:7946", priorityFailureDetection, "src", 1, []byte("ping"))
+
+	select {
+	case got := <-ft.writes:
+		if string(got) != "ping" {
+			t.Fatalf("expected wrapped Transport to receive %q, got %q", "ping", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for QueueingTransport to drain through the wrapped Transport")
+	}
+}
+
+func TestQueueingTransport_GossipPeersSkipsSlowPeer(t *testing.T) {
+	ft := newFakeTransport()
+	qt := NewQueueingTransport(ft)
+	defer qt.Shutdown()
+
+	healthy := "127.0.0.1:7946"
+	slow := "127.0.0.1:7947"
+
+	for i := 0; i < peerQueueHighWaterMark+5; i++ {
+		qt.queues.For(slow).Enqueue(priorityUser, "src", 1, []byte{byte(i)})
+	}
+
+	candidates := []string{healthy, slow}
+	out := qt.GossipPeers(candidates)
+
+	found := false
+	for _, addr := range out {
+		if addr == slow {
+			found = true
+		}
+	}
+	if found {
+		t.Fatalf("expected slow peer %q to be skipped from gossip fan-out, got %v", slow, out)
+	}
+	if len(out) != 1 || out[0] != healthy {
+		t.Fatalf("expected only healthy peer to remain, got %v", out)
+	}
+}
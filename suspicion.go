@@ -2,6 +2,7 @@ package memberlist
 
 import (
 	"math"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -15,6 +16,17 @@ type suspicion struct { //本质是一个定时器，收集到k个suspect信息
 	// timer callback.
 	n int32 //表示收到多少该节点处于suspect的消息
 
+	// confirmationsLock guards weightedN, which is not updated atomically
+	// because it's a float and is only ever touched while holding this
+	// lock alongside the confirmations map below.
+	confirmationsLock sync.Mutex
+
+	// weightedN is like n, but confirmations from senders reporting a
+	// worse awareness score count for less than a full confirmation. This
+	// means we require more corroboration from unhealthy peers before the
+	// timer is allowed to collapse to min.
+	weightedN float64
+
 	// k is the number of independent confirmations we'd like to see in
 	// order to drive the timer to its minimum value.
 	k int32 //表示达到多少个suspect确认消息后就把timer置为最小值
@@ -79,12 +91,28 @@ func newSuspicion(from string, k int, min time.Duration, max time.Duration, fn f
 	return s
 }
 
+// newSuspicionWithAwareness is like newSuspicion, but scales both the min
+// and max timer bounds by the local node's current awareness/health score
+// before starting the timer. This is the suspicion.go half of wiring
+// awareness into the suspicion timeout: a locally unhealthy node (one
+// whose own probes keep failing to get a timely response) waits longer
+// before declaring a remote node dead, so it doesn't cascade its own
+// health problems into false-positive failures. aw may be nil, in which
+// case this behaves exactly like newSuspicion.
+func newSuspicionWithAwareness(from string, k int, min, max time.Duration, aw *awareness, fn func(int)) *suspicion {
+	if aw == nil {
+		return newSuspicion(from, k, min, max, fn)
+	}
+	return newSuspicion(from, k, aw.ScaleTimeout(min), aw.ScaleTimeout(max), fn)
+}
+
 // remainingSuspicionTime takes the state variables of the suspicion timer and
 // calculates the remaining time to wait before considering a node dead. The
 // return value can be negative, so be prepared to fire the timer immediately in
-// that case. 计算还剩多少时间，时间是负的马上触发timeout，怎么算的没看懂
-func remainingSuspicionTime(n, k int32, elapsed time.Duration, min, max time.Duration) time.Duration {
-	frac := math.Log(float64(n)+1.0) / math.Log(float64(k)+1.0)
+// that case. n is the (possibly awareness-weighted) number of confirmations
+// seen so far. 计算还剩多少时间，时间是负的马上触发timeout，怎么算的没看懂
+func remainingSuspicionTime(n, k float64, elapsed time.Duration, min, max time.Duration) time.Duration {
+	frac := math.Log(n+1.0) / math.Log(k+1.0)
 	raw := max.Seconds() - frac*(max.Seconds()-min.Seconds())
 	timeout := time.Duration(math.Floor(1000.0*raw)) * time.Millisecond
 	if timeout < min {
@@ -97,28 +125,50 @@ func remainingSuspicionTime(n, k int32, elapsed time.Duration, min, max time.Dur
 }
 
 // Confirm registers that a possibly new peer has also determined the given
-// node is suspect. This returns true if this was new information, and false
-// if it was a duplicate confirmation, or if we've got enough confirmations to
-// hit the minimum.
-func (s *suspicion) Confirm(from string) bool {
-	// If we've got enough confirmations then stop accepting them.收集到足够的就停
-	if atomic.LoadInt32(&s.n) >= s.k {
+// node is suspect, weighting the confirmation by the sender's reported
+// awareness/health score (0 is fully healthy). Confirmations from an
+// unhealthy sender count for less than a full confirmation, so we require
+// more corroboration from them before the timer is allowed to collapse to
+// min. This returns true if this was new information, and false if it was a
+// duplicate confirmation, or if we've got enough confirmations to hit the
+// minimum.
+func (s *suspicion) Confirm(from string, senderScore int) bool {
+	s.confirmationsLock.Lock()
+
+	// If we've got enough weighted confirmations then stop accepting
+	// them. This must gate on weightedN, not the raw count in s.n:
+	// confirmations from unhealthy senders are worth less than a full
+	// confirmation, so k confirmations from unhealthy senders should
+	// still leave weightedN well short of k and more confirmations
+	// should still be accepted. Gating on the raw count would do the
+	// opposite of what awareness-weighting is for, shutting the door
+	// after k confirmations regardless of how little they actually
+	// moved the timer. 收集到足够的加权确认数才停
+	if s.weightedN >= float64(s.k) {
+		s.confirmationsLock.Unlock()
 		return false
 	}
 
 	// Only allow one confirmation from each possible peer.
 	if _, ok := s.confirmations[from]; ok { //不接受重复确认
+		s.confirmationsLock.Unlock()
 		return false
 	}
 	s.confirmations[from] = struct{}{}
 
+	// A healthier sender (lower score) counts for more. An unhealthy
+	// sender still moves the timer, just not as far.
+	s.weightedN += 1.0 / float64(senderScore+1)
+	weightedN := s.weightedN
+	s.confirmationsLock.Unlock()
+
 	// Compute the new timeout given the current number of confirmations and
 	// adjust the timer. If the timeout becomes negative *and* we can cleanly
 	// stop the timer then we will call the timeout function directly from
 	// here.
-	n := atomic.AddInt32(&s.n, 1)
+	atomic.AddInt32(&s.n, 1)
 	elapsed := time.Since(s.start)
-	remaining := remainingSuspicionTime(n, s.k, elapsed, s.min, s.max)
+	remaining := remainingSuspicionTime(weightedN, float64(s.k), elapsed, s.min, s.max)
 	if s.timer.Stop() { //停掉现有的timer,返回false就是已经停掉了
 		if remaining > 0 {
 			s.timer.Reset(remaining) //如果还没到时间，就加回去，等于是重新来了个timer
@@ -0,0 +1,115 @@
+package memberlist
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSuspicion_ConfirmAcceptsMoreFromUnhealthySenders(t *testing.T) {
+	// Regression test: Confirm used to gate on the raw confirmation count
+	// (s.n) while the timer math ran on the awareness-weighted count
+	// (weightedN). With k=3 and every confirmer reporting an unhealthy
+	// score of 7 (weight 1/8), three confirmations only push weightedN to
+	// 3/8 -- nowhere near k -- but the old gate would have already
+	// refused a 4th confirmation because it compared the raw count to k.
+	fired := make(chan int, 1)
+	s := newSuspicion("from", 3, time.Hour, time.Hour, func(n int) {
+		fired <- n
+	})
+
+	if !s.Confirm("a", 7) {
+		t.Fatalf("expected first confirmation to be accepted")
+	}
+	if !s.Confirm("b", 7) {
+		t.Fatalf("expected second confirmation to be accepted")
+	}
+	if !s.Confirm("c", 7) {
+		t.Fatalf("expected third confirmation to be accepted")
+	}
+
+	// With the bug, this would be rejected because atomic n (3) >= k (3).
+	// It must be accepted because weightedN is only 3/8.
+	if !s.Confirm("d", 7) {
+		t.Fatalf("expected a 4th confirmation from unhealthy senders to still be accepted, since weightedN is far below k")
+	}
+
+	select {
+	case <-fired:
+		t.Fatalf("timer should not have fired yet: weightedN is still far below k")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSuspicion_ConfirmStopsAtWeightedK(t *testing.T) {
+	fired := make(chan int, 1)
+	s := newSuspicion("from", 2, time.Millisecond, time.Hour, func(n int) {
+		fired <- n
+	})
+
+	// Healthy senders (score 0) count as a full confirmation each, so two
+	// of them should drive weightedN to k and collapse the timer to min.
+	if !s.Confirm("a", 0) {
+		t.Fatalf("expected first confirmation to be accepted")
+	}
+	if !s.Confirm("b", 0) {
+		t.Fatalf("expected second confirmation to be accepted")
+	}
+
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatalf("expected timer to collapse to min and fire after 2 healthy confirmations")
+	}
+
+	if s.Confirm("c", 0) {
+		t.Fatalf("expected confirmations to be refused once weightedN reaches k")
+	}
+}
+
+func TestSuspicion_DuplicateFromIsIgnored(t *testing.T) {
+	s := newSuspicion("from", 3, time.Hour, time.Hour, func(int) {})
+	if !s.Confirm("a", 0) {
+		t.Fatalf("expected first confirmation from a new peer to be accepted")
+	}
+	if s.Confirm("a", 0) {
+		t.Fatalf("expected a second confirmation from the same peer to be rejected")
+	}
+}
+
+func TestNewSuspicionWithAwareness_ScalesTimeout(t *testing.T) {
+	aw := newAwareness(8)
+	aw.ApplyDelta(3) // score == 3, so timeouts should scale by 4x
+
+	fired := make(chan struct{}, 1)
+	start := time.Now()
+	s := newSuspicionWithAwareness("from", 0, 10*time.Millisecond, 10*time.Millisecond, aw, func(int) {
+		fired <- struct{}{}
+	})
+	_ = s
+
+	select {
+	case <-fired:
+		if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+			t.Fatalf("expected the min timeout to be scaled by (score+1)=4, fired after only %s", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timer never fired")
+	}
+}
+
+func TestNewSuspicionWithAwareness_NilAwarenessMatchesPlain(t *testing.T) {
+	fired := make(chan struct{}, 1)
+	start := time.Now()
+	newSuspicionWithAwareness("from", 0, 10*time.Millisecond, 10*time.Millisecond, nil, func(int) {
+		fired <- struct{}{}
+	})
+
+	select {
+	case <-fired:
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Fatalf("expected an unscaled min timeout, took %s", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timer never fired")
+	}
+}
@@ -3,6 +3,23 @@ package memberlist
 import (
 	"net"
 	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+const (
+	// baseDelay is the initial, and minimum, delay slept after an Accept or
+	// packet read error before retrying.
+	baseDelay = 5 * time.Millisecond
+
+	// maxDelayTemporary is the delay cap used for errors that report
+	// themselves as temporary (net.Error.Temporary()), e.g. transient
+	// accept failures during a TLS handshake storm.
+	maxDelayTemporary = 1 * time.Second
+
+	// maxDelay is the delay cap used for all other errors, such as running
+	// out of file descriptors.
+	maxDelay = 5 * time.Second
 )
 
 // Packet is used to provide some metadata about incoming packets from peers
@@ -63,3 +80,44 @@ type Transport interface {
 	// transport a chance to clean up any listeners.
 	Shutdown() error
 }
+
+// handleAcceptErr is a shared helper for Transport implementations whose
+// accept or read loop (e.g. the TCP accept loop or the UDP read loop of
+// NetTransport) needs to ride out transient listener errors instead of
+// hot-looping on them and spamming logs, which is what happens under fd
+// exhaustion or a TLS handshake storm.
+//
+// On error, the caller should sleep for the returned duration before
+// retrying: *loopDelay starts at baseDelay and doubles on each consecutive
+// error, capped at maxDelayTemporary for errors that report themselves as
+// net.Error.Temporary() and at maxDelay otherwise. Call handleAcceptErrReset
+// on the first successful accept/read to bring the delay back to zero. This
+// is the same pattern Nomad uses for its RPC listener.
+func handleAcceptErr(err error, loopDelay *time.Duration) time.Duration {
+	if *loopDelay == 0 {
+		*loopDelay = baseDelay
+	} else {
+		*loopDelay *= 2
+	}
+
+	max := maxDelay
+	if ne, ok := err.(net.Error); ok && ne.Temporary() {
+		max = maxDelayTemporary
+	}
+	if *loopDelay > max {
+		*loopDelay = max
+	}
+
+	metrics.SetGauge([]string{"memberlist", "transport", "acceptBackoff"}, float32(*loopDelay/time.Millisecond))
+	return *loopDelay
+}
+
+// handleAcceptErrReset clears the accept-loop backoff after a successful
+// accept/read, so a single transient blip doesn't leave the loop throttled
+// indefinitely.
+func handleAcceptErrReset(loopDelay *time.Duration) {
+	if *loopDelay != 0 {
+		*loopDelay = 0
+		metrics.SetGauge([]string{"memberlist", "transport", "acceptBackoff"}, 0)
+	}
+}
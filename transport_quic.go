@@ -0,0 +1,448 @@
+package memberlist
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// QUICTransportConfig is used to configure a QUICTransport.
+type QUICTransportConfig struct {
+	// BindAddrs is a list of addresses to bind to for both sending and
+	// receiving traffic.
+	BindAddrs []string
+
+	// BindPort is the port to listen on, for each address above.
+	BindPort int
+
+	// TLSConfig is required: QUIC mandates TLS. Peers that should be
+	// allowed to join typically share a cluster CA here.
+	TLSConfig *tls.Config
+
+	// Logger is used to surface dial/accept errors. May be left nil.
+	Logger interface {
+		Printf(format string, args ...interface{})
+	}
+
+	// Codec is exchanged as the first byte of every stream we open, per
+	// the handshake described on the Codec interface, so both ends of a
+	// push/pull connection agree on an encoding before anything else is
+	// sent. Defaults to msgpack if left nil.
+	Codec Codec
+}
+
+// QUICTransport is a Transport implementation that multiplexes both the
+// packet and stream abstractions required by memberlist over a single QUIC
+// connection per peer pair. Packets ride unreliable QUIC DATAGRAM frames
+// (RFC 9221) instead of raw UDP, so they survive NAT rebinding without the
+// MTU-driven fragmentation raw UDP gossip suffers on a WAN; streams ride
+// QUIC's cheap bidirectional stream multiplexing instead of a fresh TCP
+// handshake per push/pull. A node can run a QUICTransport alongside a
+// NetTransport during a rollout; the two are independent Transport values
+// with no shared state.
+type QUICTransport struct {
+	config QUICTransportConfig
+	logger interface {
+		Printf(format string, args ...interface{})
+	}
+
+	// codec is exchanged over every stream's handshake; see
+	// QUICTransportConfig.Codec.
+	codec Codec
+
+	packetCh chan *Packet
+	streamCh chan net.Conn
+
+	listeners []*quic.Listener
+
+	connsLock sync.Mutex
+	// conns caches the outbound QUIC connection to each peer address so a
+	// WriteTo/DialTimeout pair to the same peer reuses one connection
+	// instead of paying connection setup again.
+	conns map[string]*quic.Conn
+	// dialing tracks addresses with a background dial already in flight,
+	// so a burst of WriteTo calls to the same not-yet-connected peer
+	// doesn't pile up redundant dials.
+	dialing map[string]bool
+
+	shutdownLock sync.Mutex
+	shutdown     bool
+}
+
+// quicALPN is the ALPN protocol id negotiated during the QUIC TLS
+// handshake, scoped to this package so a QUICTransport never accidentally
+// speaks to an unrelated QUIC service sharing the same port.
+const quicALPN = "memberlist/quic"
+
+// quicHandshakeTimeout bounds how long a background dial kicked off by
+// WriteTo is allowed to take before giving up, so a peer that never
+// answers doesn't leak a dial goroutine forever.
+const quicHandshakeTimeout = 10 * time.Second
+
+// NewQUICTransport creates and starts listening on all of the addresses in
+// config.BindAddrs, returning a ready-to-use QUICTransport.
+func NewQUICTransport(config *QUICTransportConfig) (*QUICTransport, error) {
+	if config.TLSConfig == nil {
+		return nil, fmt.Errorf("QUICTransport requires a TLSConfig")
+	}
+	if len(config.BindAddrs) == 0 {
+		return nil, fmt.Errorf("at least one bind address is required")
+	}
+
+	tlsConf := config.TLSConfig.Clone()
+	if len(tlsConf.NextProtos) == 0 {
+		tlsConf.NextProtos = []string{quicALPN}
+	}
+
+	codec := config.Codec
+	if codec == nil {
+		codec = defaultCodec()
+	}
+
+	t := &QUICTransport{
+		config:   *config,
+		logger:   config.Logger,
+		codec:    codec,
+		packetCh: make(chan *Packet),
+		streamCh: make(chan net.Conn),
+		conns:    make(map[string]*quic.Conn),
+		dialing:  make(map[string]bool),
+	}
+
+	quicConf := &quic.Config{
+		EnableDatagrams: true,
+	}
+
+	for _, addr := range config.BindAddrs {
+		udpAddr := &net.UDPAddr{IP: net.ParseIP(addr), Port: config.BindPort}
+		ln, err := quic.ListenAddr(udpAddr.String(), tlsConf, quicConf)
+		if err != nil {
+			t.Shutdown()
+			return nil, fmt.Errorf("failed to start QUIC listener on %q: %w", udpAddr.String(), err)
+		}
+		t.listeners = append(t.listeners, ln)
+		go t.acceptLoop(ln)
+	}
+
+	return t, nil
+}
+
+// acceptLoop accepts inbound QUIC connections on ln, and for each one spins
+// up a pair of goroutines that pump its datagrams into packetCh and its
+// accepted streams into streamCh. Transient accept errors are backed off
+// with the same helper used by NetTransport's accept loop, rather than
+// hot-looping.
+func (t *QUICTransport) acceptLoop(ln *quic.Listener) {
+	var loopDelay time.Duration
+	for {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			if t.isShutdown() {
+				return
+			}
+			delay := handleAcceptErr(err, &loopDelay)
+			if t.logger != nil {
+				t.logger.Printf("[ERR] memberlist: Error accepting QUIC connection: %v (will retry after %s)", err, delay)
+			}
+			time.Sleep(delay)
+			continue
+		}
+		handleAcceptErrReset(&loopDelay)
+
+		go t.handleDatagrams(conn, conn.RemoteAddr())
+		go t.acceptStreams(conn, conn.RemoteAddr())
+	}
+}
+
+// handleDatagrams reads unreliable QUIC datagrams off conn and turns each
+// one into a *Packet, mirroring what NetTransport's UDP read loop does for
+// raw packets. from is the address each Packet is attributed to, as passed
+// in by the caller: acceptLoop passes conn.RemoteAddr(), and dial passes
+// the address it dialed (see resolveQUICAddr).
+func (t *QUICTransport) handleDatagrams(conn *quic.Conn, from net.Addr) {
+	for {
+		buf, err := conn.ReceiveDatagram(context.Background())
+		if err != nil {
+			return
+		}
+		t.packetCh <- &Packet{
+			Buf:       buf,
+			From:      from,
+			Timestamp: time.Now(),
+		}
+	}
+}
+
+// acceptStreams accepts bidirectional streams opened by the remote end of
+// conn, runs the codec handshake with the dialer (writing our own id and
+// reading theirs, same as DialTimeout's side of it, so both ends converge
+// on the same negotiated Codec instead of only the acceptor discovering a
+// mismatch), and hands the rest to streamCh, wrapped to satisfy net.Conn
+// so the rest of memberlist's push/pull code doesn't need to know it's
+// QUIC. remoteAddr is carried on the resulting quicStreamConn as passed in
+// by the caller, the same address handleDatagrams attributes packets to.
+func (t *QUICTransport) acceptStreams(conn *quic.Conn, remoteAddr net.Addr) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+
+		negotiated, err := negotiateCodecHandshake(stream, t.codec)
+		if err != nil {
+			if t.logger != nil {
+				t.logger.Printf("[ERR] memberlist: Error negotiating codec handshake from %s: %v", remoteAddr, err)
+			}
+			stream.Close()
+			continue
+		}
+
+		t.streamCh <- &quicStreamConn{stream: stream, conn: conn, codec: negotiated, remoteAddr: remoteAddr}
+	}
+}
+
+// FinalAdvertiseAddr is given the user's configured values (which might be
+// empty) and returns the desired IP and port to advertise to the rest of
+// the cluster.
+func (t *QUICTransport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error) {
+	if ip != "" {
+		advertiseAddr := net.ParseIP(ip)
+		if advertiseAddr == nil {
+			return nil, 0, fmt.Errorf("failed to parse advertise address %q", ip)
+		}
+		return advertiseAddr, port, nil
+	}
+	if len(t.listeners) == 0 {
+		return nil, 0, fmt.Errorf("no listeners bound")
+	}
+	addr := t.listeners[0].Addr().(*net.UDPAddr)
+	return addr.IP, addr.Port, nil
+}
+
+// WriteTo fires the given payload at addr as a best-effort QUIC datagram
+// over the connection we already have cached for that peer. Unlike
+// DialTimeout, WriteTo never blocks on establishing a new QUIC connection:
+// the packet path is used for probes and gossip, which assume raw UDP's
+// microsecond-scale latency, not a multi-RTT handshake. If we don't have a
+// connection to addr yet, a dial is kicked off in the background (so a
+// later WriteTo can use it) and this packet is dropped, exactly as raw UDP
+// would drop a packet to a host that hasn't finished ARP yet.
+func (t *QUICTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	conn, ok := t.cachedConn(addr)
+	if !ok {
+		t.dialAsync(addr)
+		return time.Time{}, fmt.Errorf("no established QUIC connection to %s yet", addr)
+	}
+
+	sendTime := time.Now()
+	if err := conn.SendDatagram(b); err != nil {
+		return time.Time{}, err
+	}
+	return sendTime, nil
+}
+
+// PacketCh returns a channel that can be read to receive incoming packets
+// from other peers.
+func (t *QUICTransport) PacketCh() <-chan *Packet {
+	return t.packetCh
+}
+
+// DialTimeout opens a new bidirectional QUIC stream to addr, reusing the
+// same underlying connection WriteTo uses, runs the codec handshake with
+// the acceptor (writing our id, then reading theirs, same as
+// acceptStreams's side of it, so a dialer configured for a Codec the
+// acceptor doesn't share falls back to msgpack on both ends instead of
+// just the acceptor's), and returns the stream wrapped as a net.Conn for
+// push/pull using the negotiated Codec.
+func (t *QUICTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := t.dial(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	negotiated, err := negotiateCodecHandshake(stream, t.codec)
+	if err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("failed to negotiate codec handshake: %w", err)
+	}
+
+	return &quicStreamConn{stream: stream, conn: conn, codec: negotiated, remoteAddr: resolveQUICAddr(addr, conn)}, nil
+}
+
+// StreamCh returns a channel that can be read to handle incoming stream
+// connections from other peers.
+func (t *QUICTransport) StreamCh() <-chan net.Conn {
+	return t.streamCh
+}
+
+// Shutdown closes every listener and cached outbound connection.
+func (t *QUICTransport) Shutdown() error {
+	t.shutdownLock.Lock()
+	t.shutdown = true
+	t.shutdownLock.Unlock()
+
+	for _, ln := range t.listeners {
+		ln.Close()
+	}
+
+	t.connsLock.Lock()
+	for _, conn := range t.conns {
+		conn.CloseWithError(0, "shutdown")
+	}
+	t.connsLock.Unlock()
+
+	return nil
+}
+
+func (t *QUICTransport) isShutdown() bool {
+	t.shutdownLock.Lock()
+	defer t.shutdownLock.Unlock()
+	return t.shutdown
+}
+
+// cachedConn returns the cached QUIC connection to addr, if we have a live
+// one. It never dials.
+func (t *QUICTransport) cachedConn(addr string) (*quic.Conn, bool) {
+	t.connsLock.Lock()
+	defer t.connsLock.Unlock()
+
+	conn, ok := t.conns[addr]
+	if !ok {
+		return nil, false
+	}
+	select {
+	case <-conn.Context().Done():
+		// Stale; the caller should redial.
+		delete(t.conns, addr)
+		return nil, false
+	default:
+		return conn, true
+	}
+}
+
+// dialAsync kicks off a dial to addr in the background, unless one is
+// already in flight, and discards the result beyond caching it: it exists
+// so WriteTo can ask for a connection to be established without itself
+// blocking on the handshake.
+func (t *QUICTransport) dialAsync(addr string) {
+	t.connsLock.Lock()
+	if t.dialing[addr] {
+		t.connsLock.Unlock()
+		return
+	}
+	t.dialing[addr] = true
+	t.connsLock.Unlock()
+
+	go func() {
+		defer func() {
+			t.connsLock.Lock()
+			delete(t.dialing, addr)
+			t.connsLock.Unlock()
+		}()
+		if _, err := t.dial(addr, quicHandshakeTimeout); err != nil && t.logger != nil {
+			t.logger.Printf("[ERR] memberlist: Failed to establish QUIC connection to %s: %v", addr, err)
+		}
+	}()
+}
+
+// dial returns the cached QUIC connection to addr, establishing a new one
+// if we don't have one yet or the cached one has gone away.
+func (t *QUICTransport) dial(addr string, timeout time.Duration) (*quic.Conn, error) {
+	if conn, ok := t.cachedConn(addr); ok {
+		return conn, nil
+	}
+
+	ctx := context.Background()
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	tlsConf := t.config.TLSConfig.Clone()
+	if len(tlsConf.NextProtos) == 0 {
+		tlsConf.NextProtos = []string{quicALPN}
+	}
+	conn, err := quic.DialAddr(ctx, addr, tlsConf, &quic.Config{EnableDatagrams: true})
+	if err != nil {
+		return nil, err
+	}
+
+	t.connsLock.Lock()
+	t.conns[addr] = conn
+	t.connsLock.Unlock()
+
+	// acceptLoop only pumps datagrams/streams for *accepted* connections.
+	// Without starting the same pumps here, anything the remote end writes
+	// back on this connection we dialed — a probe ack, or its half of a
+	// push/pull it initiates in response to ours — would be silently
+	// dropped, forcing the remote to open a second connection in the
+	// reverse direction just to reply.
+	remoteAddr := resolveQUICAddr(addr, conn)
+	go t.handleDatagrams(conn, remoteAddr)
+	go t.acceptStreams(conn, remoteAddr)
+
+	return conn, nil
+}
+
+// resolveQUICAddr returns the net.Addr that traffic on a connection we
+// dialed to addr should be attributed to: the "host:port" we dialed,
+// resolved as a UDP address, so packets and streams received back on a
+// dialed connection are attributed to the address memberlist asked for. Falls
+// back to conn.RemoteAddr() if addr somehow fails to resolve here, which
+// shouldn't happen since quic.DialAddr already resolved it successfully.
+func resolveQUICAddr(addr string, conn *quic.Conn) net.Addr {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return conn.RemoteAddr()
+	}
+	return udpAddr
+}
+
+// quicStreamConn adapts a *quic.Stream (plus its parent connection, needed
+// for addresses) to the net.Conn interface expected by memberlist's
+// push/pull code. codec is the Codec negotiated for this stream during
+// the handshake in DialTimeout/acceptStreams. remoteAddr is carried
+// explicitly rather than derived from conn.RemoteAddr() on each call; see
+// resolveQUICAddr.
+type quicStreamConn struct {
+	stream     *quic.Stream
+	conn       *quic.Conn
+	codec      Codec
+	remoteAddr net.Addr
+}
+
+func (c *quicStreamConn) Read(b []byte) (int, error)  { return c.stream.Read(b) }
+func (c *quicStreamConn) Write(b []byte) (int, error) { return c.stream.Write(b) }
+func (c *quicStreamConn) Close() error                { return c.stream.Close() }
+func (c *quicStreamConn) LocalAddr() net.Addr         { return c.conn.LocalAddr() }
+func (c *quicStreamConn) RemoteAddr() net.Addr        { return c.remoteAddr }
+
+// Codec returns the Codec negotiated for this stream during the handshake,
+// so push/pull code can decode the payload that follows with the right
+// encoding.
+func (c *quicStreamConn) Codec() Codec { return c.codec }
+
+func (c *quicStreamConn) SetDeadline(t time.Time) error {
+	return c.stream.SetDeadline(t)
+}
+
+func (c *quicStreamConn) SetReadDeadline(t time.Time) error {
+	return c.stream.SetReadDeadline(t)
+}
+
+func (c *quicStreamConn) SetWriteDeadline(t time.Time) error {
+	return c.stream.SetWriteDeadline(t)
+}
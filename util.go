@@ -2,10 +2,8 @@ package memberlist
 
 import (
 	"bytes"
-	"compress/lzw"
 	"encoding/binary"
 	"fmt"
-	"io"
 	"math"
 	"math/rand"
 	"net"
@@ -24,11 +22,6 @@ import (
 // while the 65th will triple it.
 const pushPullScaleThreshold = 32
 
-const (
-	// Constant litWidth 2-8
-	lzwLitWidth = 8
-)
-
 func init() {
 	seed.Init()
 }
@@ -68,6 +61,28 @@ func suspicionTimeout(suspicionMult, n int, interval time.Duration) time.Duratio
 	return timeout
 }
 
+// suspicionTimeoutWithAwareness scales the base suspicion timeout by the
+// local node's awareness score: an unhealthy local node (one that is
+// itself failing to get timely probe responses) waits longer before
+// declaring a remote node dead, so it doesn't cascade its own health
+// problems into false-positive failures across the cluster. aw may be
+// nil, in which case this behaves exactly like suspicionTimeout, the same
+// convention newSuspicionWithAwareness uses.
+//
+// Nothing in this tree calls this yet: the probe loop that would apply
+// ApplyDelta on a failed probe/refute and read the resulting score into a
+// suspicion or an outgoing Ping/Ack lives in the state machine
+// (state.go/memberlist.go in the full package), which this source slice
+// doesn't include. The awareness/suspicion scoring machinery itself is
+// complete and tested; wiring it into a probe loop is out of scope here.
+func suspicionTimeoutWithAwareness(suspicionMult, n int, interval time.Duration, aw *awareness) time.Duration {
+	timeout := suspicionTimeout(suspicionMult, n, interval)
+	if aw == nil {
+		return timeout
+	}
+	return aw.ScaleTimeout(timeout)
+}
+
 // retransmitLimit computes the limit of retransmissions
 func retransmitLimit(retransmitMult, n int) int {
 	nodeScale := math.Ceil(math.Log10(float64(n + 1)))
@@ -216,25 +231,23 @@ func decodeCompoundMessage(buf []byte) (trunc int, parts [][]byte, err error) {
 	return
 }
 
-// compressPayload takes an opaque input buffer, compresses it
-// and wraps it in a compress{} message that is encoded. 压缩消息也是一种结构体，压缩好后，放入结构体，再编码，然后加上压缩头
-func compressPayload(inp []byte) (*bytes.Buffer, error) {
-	var buf bytes.Buffer
-	compressor := lzw.NewWriter(&buf, lzw.LSB, lzwLitWidth)
-
-	_, err := compressor.Write(inp)
-	if err != nil {
-		return nil, err
+// compressPayload takes an opaque input buffer, compresses it with the
+// given algorithm and wraps it in a compress{} message that is encoded.
+// 压缩消息也是一种结构体，压缩好后，放入结构体，再编码，然后加上压缩头
+func compressPayload(algo compressionType, inp []byte) (*bytes.Buffer, error) {
+	compressor, ok := compressAlgos[algo]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression algorithm %d", algo)
 	}
 
-	// Ensure we flush everything out
-	if err := compressor.Close(); err != nil {
+	var buf bytes.Buffer
+	if err := compressor.compress(&buf, inp); err != nil {
 		return nil, err
 	}
 
 	// Create a compressed message
 	c := compress{
-		Algo: lzwAlgo,
+		Algo: algo,
 		Buf:  buf.Bytes(),
 	}
 	return encode(compressMsg, &c)
@@ -251,27 +264,16 @@ func decompressPayload(msg []byte) ([]byte, error) {
 	return decompressBuffer(&c)
 }
 
-// decompressBuffer is used to decompress the buffer of
-// a single compress message, handling multiple algorithms
+// decompressBuffer is used to decompress the buffer of a single compress
+// message. All algorithms we know about are accepted here, not just the one
+// we are configured to produce, so that a mixed-version cluster keeps
+// gossiping correctly during a rolling upgrade.
 func decompressBuffer(c *compress) ([]byte, error) {
-	// Verify the algorithm
-	if c.Algo != lzwAlgo { //就这一种压缩方式
-		return nil, fmt.Errorf("Cannot decompress unknown algorithm %d", c.Algo)
+	decompressor, ok := compressAlgos[c.Algo]
+	if !ok {
+		return nil, fmt.Errorf("cannot decompress unknown algorithm %d", c.Algo)
 	}
-
-	// Create a uncompressor
-	uncomp := lzw.NewReader(bytes.NewReader(c.Buf), lzw.LSB, lzwLitWidth)
-	defer uncomp.Close()
-
-	// Read all the data
-	var b bytes.Buffer
-	_, err := io.Copy(&b, uncomp)
-	if err != nil {
-		return nil, err
-	}
-
-	// Return the uncompressed bytes
-	return b.Bytes(), nil
+	return decompressor.decompress(c.Buf)
 }
 
 // joinHostPort returns the host:port form of an address, for use with a
@@ -0,0 +1,23 @@
+package memberlist
+
+import "testing"
+
+func TestSuspicionTimeoutWithAwareness_NilAwarenessMatchesPlain(t *testing.T) {
+	want := suspicionTimeout(3, 5, 100)
+	got := suspicionTimeoutWithAwareness(3, 5, 100, nil)
+	if got != want {
+		t.Fatalf("expected nil awareness to leave the timeout unscaled: want %s, got %s", want, got)
+	}
+}
+
+func TestSuspicionTimeoutWithAwareness_ScalesByHealthScore(t *testing.T) {
+	aw := newAwareness(8)
+	aw.ApplyDelta(2)
+
+	base := suspicionTimeout(3, 5, 100)
+	want := aw.ScaleTimeout(base)
+	got := suspicionTimeoutWithAwareness(3, 5, 100, aw)
+	if got != want {
+		t.Fatalf("expected timeout scaled by health score: want %s, got %s", want, got)
+	}
+}